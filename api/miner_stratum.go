@@ -0,0 +1,71 @@
+package api
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/NebulousLabs/Sia/modules/miner/stratum"
+)
+
+// stratumNotifyInterval is how often the stratum pool checks whether the
+// miner's work has changed and, if so, pushes a new job to clients.
+const stratumNotifyInterval = time.Second
+
+// StartStratum opens a TCP listener on addr and serves the stratum mining
+// protocol to anything that connects, backed by srv.miner. It's called when
+// siad is run with -stratum-addr and returns once the listener is up; the
+// accept loop and job broadcaster run in the background until StopStratum
+// is called.
+func (srv *Server) StartStratum(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	pool := stratum.NewPool(srv.miner)
+	srv.stratumPool = pool
+	srv.stratumListener = listener
+	srv.stratumDone = make(chan struct{})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				// The listener was closed; stop accepting.
+				return
+			}
+			go func() {
+				if err := pool.Serve(conn); err != nil {
+					log.Println("stratum connection closed:", err)
+				}
+			}()
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(stratumNotifyInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				pool.Update()
+			case <-srv.stratumDone:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// StopStratum closes the stratum listener and stops the background job
+// broadcaster started by StartStratum. It's a no-op if stratum was never
+// started.
+func (srv *Server) StopStratum() error {
+	if srv.stratumListener == nil {
+		return nil
+	}
+	close(srv.stratumDone)
+	return srv.stratumListener.Close()
+}
@@ -1,20 +1,33 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/NebulousLabs/Sia/modules"
 	"github.com/NebulousLabs/Sia/types"
+	"github.com/julienschmidt/httprouter"
 )
 
+// progressPollInterval is how often the progress-streaming handler samples
+// a download's received-bytes counter.
+const progressPollInterval = time.Second
+
 const (
-	duration   = 6000 // Duration that hosts will hold onto the file.
-	redundancy = 15   // Redundancy of files uploaded to the network.
+	duration   = 6000 // Default duration that hosts will hold onto the file.
+	redundancy = 15   // Default redundancy of files uploaded to the network.
+
+	// erasureReplication and erasureReedSolomon are the values the
+	// "erasure" upload form field accepts.
+	erasureReplication = "replication"
+	erasureReedSolomon = "reed-solomon"
 )
 
 // DownloadInfo is a helper struct for the downloadqueue API call.
 type DownloadInfo struct {
+	ID          string
 	StartTime   time.Time
 	Complete    bool
 	Filesize    uint64
@@ -23,6 +36,35 @@ type DownloadInfo struct {
 	Nickname    string
 }
 
+// DownloadProgress is a snapshot of an in-progress download, returned by the
+// progress-streaming API call.
+type DownloadProgress struct {
+	Received uint64
+	Filesize uint64
+	Rate     float64 // bytes per second
+	ETA      time.Duration
+}
+
+// RenterFilesDownloadResponse is returned when a download is queued, so the
+// caller can correlate later cancel and progress requests with it.
+type RenterFilesDownloadResponse struct {
+	ID string
+}
+
+// downloadIdentifier is implemented by renter.Download in addition to
+// modules.DownloadInfo; it's asserted separately here since the stable ID
+// was added after the modules.DownloadInfo interface was defined.
+type downloadIdentifier interface {
+	ID() string
+}
+
+// FileHealthInfo is a helper struct for the file health API call.
+type FileHealthInfo struct {
+	TargetPieces    int
+	ActivePieces    int
+	UnderReplicated bool
+}
+
 // FileInfo is a helper struct for the files API call.
 type FileInfo struct {
 	Available      bool
@@ -39,22 +81,114 @@ type RenterFilesLoadResponse struct {
 
 // renterFilesDownloadHandler handles the API call to download a file.
 func (srv *Server) renterFilesDownloadHandler(w http.ResponseWriter, req *http.Request) {
-	err := srv.renter.Download(req.FormValue("nickname"), req.FormValue("destination"))
+	id, err := srv.renter.Download(req.FormValue("nickname"), req.FormValue("destination"))
 	if err != nil {
 		writeError(w, "Download failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	writeJSON(w, RenterFilesDownloadResponse{ID: id})
+}
+
+// renterFilesDownloadResumeHandler handles the API call to resume a
+// download that was interrupted by a restart.
+func (srv *Server) renterFilesDownloadResumeHandler(w http.ResponseWriter, req *http.Request) {
+	id, err := srv.renter.ResumeDownload(req.FormValue("id"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, RenterFilesDownloadResponse{ID: id})
+}
+
+// renterFilesDownloadCancelHandler handles the API call to cancel a queued
+// or in-progress download.
+func (srv *Server) renterFilesDownloadCancelHandler(w http.ResponseWriter, req *http.Request) {
+	id := httprouter.ParamsFromContext(req.Context()).ByName("id")
+	err := srv.renter.CancelDownload(id)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	writeSuccess(w)
 }
 
+// renterFilesDownloadProgressHandler handles the API call to stream a
+// download's progress. It writes a sequence of newline-delimited JSON
+// DownloadProgress snapshots, sampled from the download's received-bytes
+// counter, until the download completes or the client disconnects.
+func (srv *Server) renterFilesDownloadProgressHandler(w http.ResponseWriter, req *http.Request) {
+	id := httprouter.ParamsFromContext(req.Context()).ByName("id")
+
+	var dl modules.DownloadInfo
+	for _, d := range srv.renter.DownloadQueue() {
+		if idDL, ok := d.(downloadIdentifier); ok && idDL.ID() == id {
+			dl = d
+			break
+		}
+	}
+	if dl == nil {
+		writeError(w, "no download with that id", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	ticker := time.NewTicker(progressPollInterval)
+	defer ticker.Stop()
+
+	for {
+		received := dl.Received()
+		filesize := dl.Filesize()
+		elapsed := time.Since(dl.StartTime()).Seconds()
+		var rate float64
+		if elapsed > 0 {
+			rate = float64(received) / elapsed
+		}
+		var eta time.Duration
+		if rate > 0 && filesize > received {
+			eta = time.Duration(float64(filesize-received)/rate) * time.Second
+		}
+		encoder.Encode(DownloadProgress{
+			Received: received,
+			Filesize: filesize,
+			Rate:     rate,
+			ETA:      eta,
+		})
+		flusher.Flush()
+
+		if dl.Complete() {
+			return
+		}
+
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // renterDownloadqueueHandler handles the API call to request the download
 // queue.
 func (srv *Server) renterDownloadqueueHandler(w http.ResponseWriter, req *http.Request) {
 	downloads := srv.renter.DownloadQueue()
 	downloadSet := make([]DownloadInfo, 0, len(downloads))
 	for _, dl := range downloads {
+		var id string
+		if idDL, ok := dl.(downloadIdentifier); ok {
+			id = idDL.ID()
+		}
 		downloadSet = append(downloadSet, DownloadInfo{
+			ID:          id,
 			StartTime:   dl.StartTime(),
 			Complete:    dl.Complete(),
 			Filesize:    dl.Filesize(),
@@ -84,6 +218,24 @@ func (srv *Server) renterFilesListHandler(w http.ResponseWriter, req *http.Reque
 	writeJSON(w, fileSet)
 }
 
+// renterFilesHealthHandler handles the API call to report a file's current
+// redundancy, so a UI can distinguish "still uploading" from "under-
+// replicated after host churn".
+func (srv *Server) renterFilesHealthHandler(w http.ResponseWriter, req *http.Request) {
+	nickname := httprouter.ParamsFromContext(req.Context()).ByName("nickname")
+	target, active, err := srv.renter.FileHealth(nickname)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, FileHealthInfo{
+		TargetPieces:    target,
+		ActivePieces:    active,
+		UnderReplicated: active < target,
+	})
+}
+
 // renterFilesDeleteHander handles the API call to delete a file entry from the
 // renter.
 func (srv *Server) renterFilesDeleteHandler(w http.ResponseWriter, req *http.Request) {
@@ -161,15 +313,63 @@ func (srv *Server) renterStatusHandler(w http.ResponseWriter, req *http.Request)
 	writeJSON(w, srv.renter.Info())
 }
 
-// renterFilesUploadHandler handles the API call to upload a file.
+// renterFilesUploadHandler handles the API call to upload a file. Duration,
+// redundancy, and the erasure scheme all default to the historical
+// behavior (a fixed duration and pure replication) but can be overridden
+// per upload via form values.
 func (srv *Server) renterFilesUploadHandler(w http.ResponseWriter, req *http.Request) {
-	err := srv.renter.Upload(modules.FileUploadParams{
+	params := modules.FileUploadParams{
 		Filename: req.FormValue("source"),
 		Duration: duration,
 		Nickname: req.FormValue("nickname"),
 		Pieces:   redundancy,
-	})
-	if err != nil {
+	}
+
+	if v := req.FormValue("duration"); v != "" {
+		d, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, "Malformed duration: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		params.Duration = types.BlockHeight(d)
+	}
+
+	erasure := req.FormValue("erasure")
+	if erasure == "" {
+		erasure = erasureReplication
+	}
+	params.ErasureCode = erasure
+
+	switch erasure {
+	case erasureReplication:
+		if v := req.FormValue("redundancy"); v != "" {
+			pieces, err := strconv.Atoi(v)
+			if err != nil {
+				writeError(w, "Malformed redundancy: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			params.Pieces = pieces
+		}
+	case erasureReedSolomon:
+		dataPieces, err := strconv.Atoi(req.FormValue("dataPieces"))
+		if err != nil {
+			writeError(w, "Malformed dataPieces: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		parityPieces, err := strconv.Atoi(req.FormValue("parityPieces"))
+		if err != nil {
+			writeError(w, "Malformed parityPieces: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		params.DataPieces = dataPieces
+		params.ParityPieces = parityPieces
+		params.Pieces = dataPieces + parityPieces
+	default:
+		writeError(w, "Unknown erasure scheme: "+erasure, http.StatusBadRequest)
+		return
+	}
+
+	if err := srv.renter.Upload(params); err != nil {
 		writeError(w, "Upload failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,12 @@
+package api
+
+import "net/http"
+
+// registerRenterRoutes wires the renter's HTTP endpoints into srv.router.
+// It's called once, from NewServer.
+func (srv *Server) registerRenterRoutes() {
+	srv.router.Handler("POST", "/renter/downloads/:id/cancel", http.HandlerFunc(srv.renterFilesDownloadCancelHandler))
+	srv.router.Handler("GET", "/renter/downloads/:id/progress", http.HandlerFunc(srv.renterFilesDownloadProgressHandler))
+	srv.router.Handler("POST", "/renter/downloads/resume", http.HandlerFunc(srv.renterFilesDownloadResumeHandler))
+	srv.router.Handler("GET", "/renter/files/:nickname/health", http.HandlerFunc(srv.renterFilesHealthHandler))
+}
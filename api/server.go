@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/modules/miner/stratum"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Server exposes the daemon's renter and miner functionality over HTTP,
+// and, when started with StartStratum, the miner's stratum protocol over
+// its own raw TCP listener.
+type Server struct {
+	renter modules.Renter
+	miner  modules.Miner
+
+	router *httprouter.Router
+
+	// stratumPool, stratumListener, and stratumDone are set by
+	// StartStratum and torn down by StopStratum; all three are nil if
+	// stratum was never started.
+	stratumPool     *stratum.Pool
+	stratumListener net.Listener
+	stratumDone     chan struct{}
+}
+
+// NewServer creates a Server backed by renter and miner, with all of the
+// package's HTTP routes registered on it.
+func NewServer(renter modules.Renter, miner modules.Miner) *Server {
+	srv := &Server{
+		renter: renter,
+		miner:  miner,
+		router: httprouter.New(),
+	}
+	srv.registerRenterRoutes()
+	return srv
+}
+
+// ListenAndServe serves the API on addr until the listener errors or the
+// process exits.
+func (srv *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, srv.router)
+}
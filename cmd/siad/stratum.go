@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/NebulousLabs/Sia/api"
+)
+
+// stratumAddr is the address to serve the stratum mining protocol on. It's
+// left empty by default, since most nodes mine (if at all) through the
+// HTTP API rather than exposing a raw TCP listener.
+var stratumAddr = flag.String("stratum-addr", "", "address to serve the stratum mining protocol on (disabled if empty)")
+
+// startStratumIfConfigured starts the stratum listener when siad was
+// invoked with -stratum-addr, so external ASIC/GPU miners and pool proxies
+// can connect directly instead of polling the HTTP mining API.
+func startStratumIfConfigured(srv *api.Server) {
+	if *stratumAddr == "" {
+		return
+	}
+	if err := srv.StartStratum(*stratumAddr); err != nil {
+		log.Fatalln("failed to start stratum server:", err)
+	}
+}
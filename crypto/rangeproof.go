@@ -0,0 +1,91 @@
+package crypto
+
+// RangeProofSegmentSize is the size, in bytes, of a single leaf in the
+// Merkle tree used to prove that a downloaded byte range belongs to a
+// larger file. Renter downloads fetch exactly one segment per RPC, so a
+// range proof never needs to span more than one leaf.
+const RangeProofSegmentSize = 1 << 22 // 4 MiB
+
+// merkleParent hashes two child nodes together to produce their parent.
+func merkleParent(left, right Hash) Hash {
+	return HashBytes(append(append([]byte{}, left[:]...), right[:]...))
+}
+
+// merkleLevel returns the parent level of a tree. If the level has an odd
+// number of nodes, the final one is duplicated so every node always has a
+// sibling, keeping the proof format simple.
+func merkleLevel(level []Hash) []Hash {
+	if len(level)%2 == 1 {
+		level = append(level, level[len(level)-1])
+	}
+	parents := make([]Hash, len(level)/2)
+	for i := range parents {
+		parents[i] = merkleParent(level[2*i], level[2*i+1])
+	}
+	return parents
+}
+
+// MerkleRoot returns the root of the Merkle tree built over leaves.
+func MerkleRoot(leaves []Hash) Hash {
+	level := append([]Hash{}, leaves...)
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+	return level[0]
+}
+
+// MerkleRangeProof returns the sibling hashes needed to reconstruct the
+// Merkle root of leaves from leaves[index] alone, in leaf-to-root order.
+// It's used by a host to prove that the segment it's serving belongs to
+// the file the renter contracted for.
+func MerkleRangeProof(leaves []Hash, index int) []Hash {
+	var branch []Hash
+	level := append([]Hash{}, leaves...)
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		branch = append(branch, level[index^1])
+		level = merkleLevel(level)
+		index /= 2
+	}
+	return branch
+}
+
+// FileMerkleRoot returns the Merkle root of data, split into
+// RangeProofSegmentSize leaves and hashed with HashBytes — the exact
+// construction a host's storage obligation and VerifyRangeProof use.
+// Contract formation must record this same root as the piece's
+// FileMerkleRoot, or range proofs against it will never verify.
+func FileMerkleRoot(data []byte) Hash {
+	var leaves []Hash
+	for offset := 0; offset < len(data); offset += RangeProofSegmentSize {
+		end := offset + RangeProofSegmentSize
+		if end > len(data) {
+			end = len(data)
+		}
+		leaves = append(leaves, HashBytes(data[offset:end]))
+	}
+	return MerkleRoot(leaves)
+}
+
+// VerifyRangeProof reports whether the byte range [offset, offset+length)
+// of a file, whose contents hash to rangeRoot, is part of the file whose
+// full Merkle root is fileRoot. branch is the sibling path a host returns
+// alongside the range when serving the retrieve-range RPC.
+func VerifyRangeProof(rangeRoot Hash, branch []Hash, offset, length uint64, fileRoot Hash) bool {
+	if offset%RangeProofSegmentSize != 0 || length > RangeProofSegmentSize {
+		return false
+	}
+	index := offset / RangeProofSegmentSize
+	root := rangeRoot
+	for _, sibling := range branch {
+		if index%2 == 0 {
+			root = merkleParent(root, sibling)
+		} else {
+			root = merkleParent(sibling, root)
+		}
+		index /= 2
+	}
+	return root == fileRoot
+}
@@ -0,0 +1,23 @@
+package modules
+
+import "github.com/NebulousLabs/Sia/types"
+
+// FileUploadParams are the parameters passed to Renter.Upload, specifying
+// what to upload, how long to store it, and how redundant it should be.
+type FileUploadParams struct {
+	Filename string
+	Duration types.BlockHeight
+	Nickname string
+
+	// Pieces is the total number of pieces the file is split into. Under
+	// pure replication every piece is a full copy of the data; under
+	// Reed-Solomon it's DataPieces + ParityPieces.
+	Pieces int
+
+	// ErasureCode selects how Pieces are derived from the file's data:
+	// "replication" (the default) or "reed-solomon". DataPieces and
+	// ParityPieces are only meaningful for "reed-solomon".
+	ErasureCode  string
+	DataPieces   int
+	ParityPieces int
+}
@@ -0,0 +1,65 @@
+package host
+
+import (
+	"sync"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// obligation tracks the data a host has agreed to store under a single
+// file contract, along with the Merkle tree needed to answer ranged
+// retrieve requests without re-reading (and re-hashing) the whole file.
+type obligation struct {
+	fileSize   uint64
+	fileRoot   crypto.Hash
+	segments   [][]byte
+	segmentIDs []crypto.Hash
+}
+
+// Host answers RPCs from renters that have contracted storage on it. Only
+// the state needed to serve ranged retrieves is modeled here.
+type Host struct {
+	mu          sync.RWMutex
+	obligations map[types.FileContractID]*obligation
+}
+
+// New creates a Host with no storage obligations.
+func New() *Host {
+	return &Host{
+		obligations: make(map[types.FileContractID]*obligation),
+	}
+}
+
+// AddObligation registers data as being stored under contractID, splitting
+// it into the fixed-size segments that retrieve-range proofs are built
+// over and precomputing their hashes.
+func (h *Host) AddObligation(contractID types.FileContractID, data []byte) {
+	var segments [][]byte
+	var segmentIDs []crypto.Hash
+	for offset := 0; offset < len(data); offset += crypto.RangeProofSegmentSize {
+		end := offset + crypto.RangeProofSegmentSize
+		if end > len(data) {
+			end = len(data)
+		}
+		segments = append(segments, data[offset:end])
+		segmentIDs = append(segmentIDs, crypto.HashBytes(data[offset:end]))
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.obligations[contractID] = &obligation{
+		fileSize:   uint64(len(data)),
+		fileRoot:   crypto.MerkleRoot(segmentIDs),
+		segments:   segments,
+		segmentIDs: segmentIDs,
+	}
+}
+
+// obligationFor returns the obligation stored under contractID, if any.
+func (h *Host) obligationFor(contractID types.FileContractID) (*obligation, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ob, exists := h.obligations[contractID]
+	return ob, exists
+}
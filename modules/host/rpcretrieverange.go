@@ -0,0 +1,72 @@
+package host
+
+import (
+	"errors"
+	"net"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// rpcRetrieveRangeID is the specifier a renter writes to a connection to
+// request a byte range of a stored file piece instead of the whole thing.
+// It's the same identifier renter.downloadRange sends.
+var rpcRetrieveRangeID = [8]byte{'R', 'e', 't', 'r', 'i', 'e', 'v', 'e'}
+
+// Serve reads a single RPC identifier off conn and dispatches it, closing
+// the connection when the RPC completes. It's meant to be called in its
+// own goroutine per incoming connection.
+func (h *Host) Serve(conn net.Conn) error {
+	defer conn.Close()
+
+	var rpcID [8]byte
+	if err := encoding.ReadObject(conn, &rpcID, 8); err != nil {
+		return err
+	}
+	switch rpcID {
+	case rpcRetrieveRangeID:
+		return h.managedRPCRetrieveRange(conn)
+	default:
+		return errors.New("unrecognized RPC id")
+	}
+}
+
+// managedRPCRetrieveRange serves a single ranged retrieve. It mirrors the
+// wire format renter.downloadRange expects: after the contract ID, the
+// renter sends the offset and length of the range it wants, and the host
+// answers with the Merkle branch needed to verify the range against the
+// file's full Merkle root, followed by the range itself.
+func (h *Host) managedRPCRetrieveRange(conn net.Conn) error {
+	var contractID types.FileContractID
+	if err := encoding.ReadObject(conn, &contractID, encoding.DefaultAllocLimit); err != nil {
+		return err
+	}
+	var offset, length uint64
+	if err := encoding.ReadObject(conn, &offset, 8); err != nil {
+		return err
+	}
+	if err := encoding.ReadObject(conn, &length, 8); err != nil {
+		return err
+	}
+
+	ob, exists := h.obligationFor(contractID)
+	if !exists {
+		return errors.New("no storage obligation for that contract")
+	}
+	if offset%crypto.RangeProofSegmentSize != 0 || offset+length > ob.fileSize {
+		return errors.New("requested range is out of bounds")
+	}
+	index := int(offset / crypto.RangeProofSegmentSize)
+	if index >= len(ob.segments) || uint64(len(ob.segments[index])) < length {
+		return errors.New("requested range is out of bounds")
+	}
+
+	branch := crypto.MerkleRangeProof(ob.segmentIDs, index)
+	if err := encoding.WriteObject(conn, branch); err != nil {
+		return err
+	}
+
+	_, err := conn.Write(ob.segments[index][:length])
+	return err
+}
@@ -0,0 +1,378 @@
+// Package stratum implements the server side of the JSON-RPC-over-TCP
+// stratum mining protocol on top of a modules.Miner, so that external
+// ASIC/GPU miners and pool proxies can connect to a Sia node directly
+// instead of polling the HTTP mining API.
+package stratum
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// extranonce1Size and extranonce2Size are the number of bytes of a
+// types.BlockNonce given over to the server-assigned extranonce1 and the
+// client-chosen extranonce2, respectively. The remainder is left for the
+// nonce the mining hardware actually iterates, so their sum must leave at
+// least a few bytes of search space within len(types.BlockNonce).
+const (
+	extranonce1Size = 2
+	extranonce2Size = 2
+)
+
+// maxTarget is the easiest possible target, used as the numerator when
+// translating a types.Target into a stratum difficulty.
+var maxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// maxTrackedJobs bounds how many past jobs a Pool keeps in memory to
+// accept mining.submit against. Shares for anything older than that are
+// rejected as stale, which is fine in practice since miners resubmit
+// against the newest job almost immediately after each mining.notify.
+const maxTrackedJobs = 4
+
+// job is a unit of work handed out to clients via mining.notify.
+type job struct {
+	id     string
+	header types.BlockHeader
+	target types.Target
+}
+
+// Pool serves the stratum protocol to connected clients, translating
+// mining.subscribe/authorize/submit calls into calls on the underlying
+// miner and pushing mining.notify/set_difficulty whenever new work is
+// available.
+type Pool struct {
+	miner modules.Miner
+
+	mu         sync.Mutex
+	clients    map[*clientConn]struct{}
+	currentJob job
+	jobs       map[string]job
+	jobOrder   []string // job IDs in the order they were created, oldest first
+	jobCounter uint64
+}
+
+// NewPool creates a stratum Pool backed by miner.
+func NewPool(miner modules.Miner) *Pool {
+	return &Pool{
+		miner:   miner,
+		clients: make(map[*clientConn]struct{}),
+		jobs:    make(map[string]job),
+	}
+}
+
+// clientConn is a single stratum client connection.
+type clientConn struct {
+	pool    *Pool
+	conn    net.Conn
+	encoder *json.Encoder
+
+	// writeMu serializes writes to encoder, since sendJob is called from
+	// the pool's broadcast/ticker goroutine while responses to individual
+	// RPCs are written from Serve's own goroutine.
+	writeMu sync.Mutex
+
+	mu          sync.Mutex
+	subscribed  bool
+	authorized  bool
+	extranonce1 string
+}
+
+// rpcRequest is a stratum JSON-RPC request.
+type rpcRequest struct {
+	ID     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// rpcResponse is a stratum JSON-RPC response.
+type rpcResponse struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result,omitempty"`
+	Error  interface{} `json:"error"`
+}
+
+// rpcNotification is a stratum JSON-RPC notification (a request with no ID
+// that expects no response).
+type rpcNotification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// randomExtranonce1 returns a fresh, per-connection extranonce1.
+func randomExtranonce1() string {
+	b := make([]byte, extranonce1Size)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// difficulty converts a types.Target into the floating-point difficulty
+// stratum clients expect, where difficulty 1 is the easiest target.
+func difficulty(target types.Target) float64 {
+	t := new(big.Int).SetBytes(target[:])
+	if t.Sign() == 0 {
+		return 0
+	}
+	diff := new(big.Rat).SetFrac(maxTarget, t)
+	f, _ := diff.Float64()
+	return f
+}
+
+// newJob asks the miner for the current work and wraps it in a job with a
+// fresh ID.
+func (p *Pool) newJob() job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	header, target := p.miner.HeaderForWork()
+	p.jobCounter++
+	j := job{
+		id:     strconv.FormatUint(p.jobCounter, 16),
+		header: header,
+		target: target,
+	}
+	p.currentJob = j
+	p.jobs[j.id] = j
+	p.jobOrder = append(p.jobOrder, j.id)
+
+	// Prune jobs beyond maxTrackedJobs so a long-running pool doesn't grow
+	// p.jobs without bound. Clients submitting against a pruned job just
+	// get "stale or unknown job id", which is the same outcome as
+	// submitting against work from before the pool started.
+	for len(p.jobOrder) > maxTrackedJobs {
+		delete(p.jobs, p.jobOrder[0])
+		p.jobOrder = p.jobOrder[1:]
+	}
+	return j
+}
+
+// Update checks the miner for new work and, if it differs from the job
+// currently being handed out, broadcasts it to all subscribed clients. Call
+// it periodically (e.g. from a ticker) to approximate push notification of
+// new work in the absence of a change-notification channel on the miner.
+func (p *Pool) Update() {
+	header, _ := p.miner.HeaderForWork()
+
+	p.mu.Lock()
+	changed := p.currentJob.header.ParentID != header.ParentID ||
+		p.currentJob.header.MerkleRoot != header.MerkleRoot
+	p.mu.Unlock()
+
+	if changed {
+		p.Broadcast()
+	}
+}
+
+// Broadcast pushes the miner's current work to every subscribed client as a
+// new stratum job. Call it whenever the block to mine on has changed (e.g.
+// on a new block being accepted, or periodically as a fallback).
+func (p *Pool) Broadcast() {
+	j := p.newJob()
+
+	p.mu.Lock()
+	clients := make([]*clientConn, 0, len(p.clients))
+	for c := range p.clients {
+		clients = append(clients, c)
+	}
+	p.mu.Unlock()
+
+	for _, c := range clients {
+		c.sendJob(j)
+	}
+}
+
+// Serve handles a single stratum connection until it is closed or a
+// protocol error occurs. It registers the connection with the pool so it
+// receives future mining.notify broadcasts, and unregisters it on return.
+func (p *Pool) Serve(conn net.Conn) error {
+	c := &clientConn{
+		pool:        p,
+		conn:        conn,
+		encoder:     json.NewEncoder(conn),
+		extranonce1: randomExtranonce1(),
+	}
+
+	p.mu.Lock()
+	p.clients[c] = struct{}{}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.clients, c)
+		p.mu.Unlock()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var req rpcRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			c.sendError(req.ID, err)
+			continue
+		}
+		if err := c.handle(req); err != nil {
+			c.sendError(req.ID, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// handle dispatches a single stratum method call.
+func (c *clientConn) handle(req rpcRequest) error {
+	switch req.Method {
+	case "mining.subscribe":
+		return c.handleSubscribe(req)
+	case "mining.authorize":
+		return c.handleAuthorize(req)
+	case "mining.submit":
+		return c.handleSubmit(req)
+	default:
+		return errors.New("unknown stratum method: " + req.Method)
+	}
+}
+
+// encode writes v to the connection, serializing it against any other
+// write in flight (sendJob is called from the pool's broadcast/ticker
+// goroutine concurrently with RPC responses written from Serve's own
+// goroutine).
+func (c *clientConn) encode(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.encoder.Encode(v)
+}
+
+// handleSubscribe registers the client for mining.notify and assigns it an
+// extranonce1.
+func (c *clientConn) handleSubscribe(req rpcRequest) error {
+	c.mu.Lock()
+	c.subscribed = true
+	c.mu.Unlock()
+
+	err := c.encode(rpcResponse{
+		ID: req.ID,
+		Result: []interface{}{
+			[][]string{{"mining.notify", c.extranonce1}},
+			c.extranonce1,
+			extranonce2Size,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Send the client its first job and difficulty immediately rather than
+	// waiting for the next broadcast.
+	c.sendJob(c.pool.newJob())
+	return nil
+}
+
+// handleAuthorize marks the connection as authorized. The pool doesn't
+// currently do per-worker accounting, so any username/password is accepted.
+func (c *clientConn) handleAuthorize(req rpcRequest) error {
+	c.mu.Lock()
+	c.authorized = true
+	c.mu.Unlock()
+
+	return c.encode(rpcResponse{ID: req.ID, Result: true})
+}
+
+// handleSubmit decodes a submitted share, reconstructs the full header, and
+// hands it to the miner.
+func (c *clientConn) handleSubmit(req rpcRequest) error {
+	// params: [worker, jobID, extranonce2, ntime, nonce]
+	if len(req.Params) < 5 {
+		return errors.New("malformed mining.submit")
+	}
+	var jobID, extranonce2, ntime, nonceHex string
+	if err := json.Unmarshal(req.Params[1], &jobID); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(req.Params[2], &extranonce2); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(req.Params[3], &ntime); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(req.Params[4], &nonceHex); err != nil {
+		return err
+	}
+
+	c.pool.mu.Lock()
+	j, ok := c.pool.jobs[jobID]
+	c.pool.mu.Unlock()
+	if !ok {
+		return errors.New("stale or unknown job id")
+	}
+
+	nonce, err := assembleNonce(c.extranonce1, extranonce2, nonceHex)
+	if err != nil {
+		return err
+	}
+	header := j.header
+	header.Nonce = nonce
+
+	if err := c.pool.miner.SubmitHeader(header); err != nil {
+		return c.encode(rpcResponse{ID: req.ID, Result: false, Error: err.Error()})
+	}
+	return c.encode(rpcResponse{ID: req.ID, Result: true})
+}
+
+// assembleNonce combines the server-assigned extranonce1, the client-chosen
+// extranonce2, and the submitted nonce into a types.BlockNonce.
+func assembleNonce(extranonce1, extranonce2, nonceHex string) (types.BlockNonce, error) {
+	var nonce types.BlockNonce
+	combined, err := hex.DecodeString(extranonce1 + extranonce2 + nonceHex)
+	if err != nil {
+		return nonce, err
+	}
+	if len(combined) != len(nonce) {
+		return nonce, errors.New("nonce has the wrong length")
+	}
+	copy(nonce[:], combined)
+	return nonce, nil
+}
+
+// sendJob pushes mining.set_difficulty followed by mining.notify for j to
+// the client.
+func (c *clientConn) sendJob(j job) {
+	c.mu.Lock()
+	subscribed := c.subscribed
+	c.mu.Unlock()
+	if !subscribed {
+		return
+	}
+
+	c.encode(rpcNotification{
+		Method: "mining.set_difficulty",
+		Params: []interface{}{difficulty(j.target)},
+	})
+	c.encode(rpcNotification{
+		Method: "mining.notify",
+		Params: []interface{}{
+			j.id,
+			hex.EncodeToString(encodeHeader(j.header)),
+			true, // clean_jobs
+		},
+	})
+}
+
+// sendError reports a protocol or handling error back to the client.
+func (c *clientConn) sendError(id interface{}, err error) {
+	c.encode(rpcResponse{ID: id, Error: err.Error()})
+}
+
+// encodeHeader serializes a block header for transmission in mining.notify.
+// The nonce is zeroed, since it's the client's job to fill it in.
+func encodeHeader(h types.BlockHeader) []byte {
+	h.Nonce = types.BlockNonce{}
+	return encoding.Marshal(h)
+}
@@ -1,11 +1,14 @@
 package renter
 
 import (
+	"context"
 	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"io"
 	"net"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -14,8 +17,22 @@ import (
 	"github.com/NebulousLabs/Sia/modules"
 )
 
-var (
-	downloadAttempts = 5
+const (
+	// maxConcurrentPieces bounds the number of ranged fetches the
+	// downloadScheduler will have in flight at once.
+	maxConcurrentPieces = 4
+
+	// maxRangeAttempts is the number of hosts the scheduler will try for a
+	// single chunk before giving up on it.
+	maxRangeAttempts = 5
+
+	// maxHostFailures is the number of consecutive failures a host is
+	// allowed before the scheduler demotes it in favor of a healthier host.
+	maxHostFailures = 3
+
+	// downloadChunkSize is the size of the byte ranges the file is split
+	// into for parallel retrieval.
+	downloadChunkSize = 1 << 22 // 4 MiB
 )
 
 // A Download is a file download that has been queued by the renter. It
@@ -26,16 +43,45 @@ type Download struct {
 	// correctly on ARM and x86-32.
 	received uint64
 
+	id          string
 	startTime   time.Time
 	complete    bool
 	filesize    uint64
 	destination string
 	nickname    string
 
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// doneChunks and chunkHashes track, per entry of chunksForFilesize,
+	// whether that chunk has already been written to disk and what its
+	// contents hashed to when it was. They're checkpointed to disk so an
+	// interrupted download can resume without re-fetching completed chunks.
+	doneMu      sync.Mutex
+	doneChunks  []bool
+	chunkHashes []crypto.Hash
+
+	// onProgress, if set, is called after each chunk completes so the
+	// renter can persist an updated checkpoint.
+	onProgress func()
+
 	pieces []filePiece
 	file   *os.File
 }
 
+// ID returns the download's unique identifier, assigned when it was queued.
+// Clients use it to correlate a queued download with later cancel and
+// progress requests.
+func (d *Download) ID() string {
+	return d.id
+}
+
+// Cancel aborts the download. Any chunk workers currently in flight will
+// stop as soon as they next check the download's context.
+func (d *Download) Cancel() {
+	d.cancel()
+}
+
 // StartTime returns when the download was initiated.
 func (d *Download) StartTime() time.Time {
 	return d.startTime
@@ -66,18 +112,244 @@ func (d *Download) Nickname() string {
 	return d.nickname
 }
 
-// Write implements the io.Writer interface. Each write updates the Download's
-// received field. This allows download progress to be monitored in real-time.
-func (d *Download) Write(b []byte) (int, error) {
-	n, err := d.file.Write(b)
+// chunkWriter is an io.Writer that writes a ranged fetch into the
+// destination file at a fixed offset, advancing as it goes. It allows
+// multiple chunk workers to write into the same file concurrently via
+// WriteAt while still reporting aggregate progress through d.received.
+// written tracks the bytes this particular attempt has contributed, so a
+// failed attempt can undo them instead of leaving them counted forever.
+type chunkWriter struct {
+	d       *Download
+	offset  int64
+	written uint64
+}
+
+// Write implements the io.Writer interface. Each write updates the
+// Download's received field. This allows download progress to be monitored
+// in real-time even though chunks are written out of order.
+func (cw *chunkWriter) Write(b []byte) (int, error) {
+	n, err := cw.d.file.WriteAt(b, cw.offset)
+	cw.offset += int64(n)
+	cw.written += uint64(n)
 	// atomically update d.received
-	// TODO: atomic operations may not be necessary
-	atomic.AddUint64(&d.received, uint64(n))
+	atomic.AddUint64(&cw.d.received, uint64(n))
 	return n, err
 }
 
-// downloadPiece attempts to retrieve a file piece from a host.
-func (d *Download) downloadPiece(piece filePiece) error {
+// undo reverses the effect of Write on d.received, for when the attempt
+// that produced these bytes ultimately failed and will be retried from
+// scratch against a different host.
+func (cw *chunkWriter) undo() {
+	if cw.written == 0 {
+		return
+	}
+	// Subtract cw.written from the unsigned counter via two's-complement
+	// negation, since atomic has no SubUint64.
+	atomic.AddUint64(&cw.d.received, ^(cw.written - 1))
+}
+
+// downloadChunk is a byte range of the file being downloaded.
+type downloadChunk struct {
+	index  int
+	offset uint64
+	length uint64
+}
+
+// chunksForFilesize splits a file of the given size into downloadChunkSize
+// ranges.
+func chunksForFilesize(filesize uint64) []downloadChunk {
+	var chunks []downloadChunk
+	for offset := uint64(0); offset < filesize; offset += downloadChunkSize {
+		length := downloadChunkSize
+		if offset+uint64(length) > filesize {
+			length = int(filesize - offset)
+		}
+		chunks = append(chunks, downloadChunk{index: len(chunks), offset: offset, length: uint64(length)})
+	}
+	return chunks
+}
+
+// chunkDone reports whether the chunk at index has already been written to
+// disk and verified.
+func (d *Download) chunkDone(index int) bool {
+	d.doneMu.Lock()
+	defer d.doneMu.Unlock()
+	return d.doneChunks[index]
+}
+
+// markChunkDone records that the chunk at index has been written to disk
+// and hashes to h, then notifies onProgress so the download's checkpoint
+// can be persisted.
+func (d *Download) markChunkDone(index int, h crypto.Hash) {
+	d.doneMu.Lock()
+	d.doneChunks[index] = true
+	d.chunkHashes[index] = h
+	d.doneMu.Unlock()
+
+	if d.onProgress != nil {
+		d.onProgress()
+	}
+}
+
+// downloadScheduler fetches the chunks of a Download across a pool of
+// worker goroutines, spreading the work across the redundant pieces the
+// file was uploaded with and demoting hosts that are slow or failing.
+type downloadScheduler struct {
+	d      *Download
+	pieces []filePiece
+
+	mu       sync.Mutex
+	failures map[modules.NetAddress]int
+}
+
+// newDownloadScheduler creates a scheduler for d.
+func newDownloadScheduler(d *Download) *downloadScheduler {
+	return &downloadScheduler{
+		d:        d,
+		pieces:   d.pieces,
+		failures: make(map[modules.NetAddress]int),
+	}
+}
+
+// recordResult updates the health of the host that served (or failed to
+// serve) a chunk.
+func (s *downloadScheduler) recordResult(host modules.NetAddress, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.failures[host] = 0
+		return
+	}
+	s.failures[host]++
+}
+
+// selectPiece returns the healthiest piece that hasn't already been tried
+// for the current chunk. Hosts that have failed maxHostFailures times in a
+// row are skipped as long as a healthier alternative remains.
+func (s *downloadScheduler) selectPiece(tried map[modules.NetAddress]bool) *filePiece {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var best, demoted *filePiece
+	bestFailures := maxHostFailures
+	for i := range s.pieces {
+		piece := &s.pieces[i]
+		if tried[piece.HostIP] {
+			continue
+		}
+		failures := s.failures[piece.HostIP]
+		if failures < bestFailures {
+			best = piece
+			bestFailures = failures
+		} else if failures >= maxHostFailures && demoted == nil {
+			demoted = piece
+		}
+	}
+	if best == nil {
+		return demoted
+	}
+	return best
+}
+
+// backoff returns how long to wait before the next retry, using an
+// exponential backoff with random jitter to avoid retry storms.
+func backoff(attempt int) time.Duration {
+	jitter := make([]byte, 1)
+	rand.Read(jitter)
+	return time.Duration(1<<uint(attempt))*time.Second + time.Duration(jitter[0])*time.Millisecond
+}
+
+// fetchChunk downloads a single chunk, trying alternate hosts if the
+// current one fails or times out.
+func (s *downloadScheduler) fetchChunk(chunk downloadChunk) error {
+	tried := make(map[modules.NetAddress]bool)
+	var lastErr error
+	for attempt := 0; attempt < maxRangeAttempts; attempt++ {
+		if err := s.d.ctx.Err(); err != nil {
+			return err
+		}
+		piece := s.selectPiece(tried)
+		if piece == nil {
+			break
+		}
+
+		cw := &chunkWriter{d: s.d, offset: int64(chunk.offset)}
+		err := downloadRange(*piece, chunk, cw)
+		s.recordResult(piece.HostIP, err)
+		if err == nil {
+			s.d.markChunkDone(chunk.index, chunkHash(s.d.file, chunk))
+			return nil
+		}
+		cw.undo()
+
+		lastErr = err
+		tried[piece.HostIP] = true
+		time.Sleep(backoff(attempt))
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no hosts available to serve chunk")
+	}
+	return lastErr
+}
+
+// chunkHash reads back the bytes just written for chunk and hashes them, so
+// they can be verified without contacting a host if the download is
+// resumed later.
+func chunkHash(file *os.File, chunk downloadChunk) crypto.Hash {
+	buf := make([]byte, chunk.length)
+	file.ReadAt(buf, int64(chunk.offset))
+	return crypto.HashBytes(buf)
+}
+
+// run fetches every chunk of the download that isn't already marked done
+// (e.g. by a prior, interrupted attempt) using a bounded pool of worker
+// goroutines, returning the first error encountered.
+func (s *downloadScheduler) run() error {
+	var chunks []downloadChunk
+	for _, chunk := range chunksForFilesize(s.d.filesize) {
+		if !s.d.chunkDone(chunk.index) {
+			chunks = append(chunks, chunk)
+		}
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	jobs := make(chan downloadChunk, len(chunks))
+	for _, chunk := range chunks {
+		jobs <- chunk
+	}
+	close(jobs)
+
+	errs := make(chan error, len(chunks))
+	var wg sync.WaitGroup
+	workers := maxConcurrentPieces
+	if workers > len(chunks) {
+		workers = len(chunks)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range jobs {
+				errs <- s.fetchChunk(chunk)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadRange retrieves a byte range of a file piece from a host and
+// writes the recovered bytes to w.
+func downloadRange(piece filePiece, chunk downloadChunk, w io.Writer) error {
 	conn, err := net.DialTimeout("tcp", string(piece.HostIP), 10e9)
 	if err != nil {
 		return err
@@ -88,34 +360,61 @@ func (d *Download) downloadPiece(piece filePiece) error {
 		return err
 	}
 
-	// Send the ID of the contract for the file piece we're requesting.
+	// Send the ID of the contract for the file piece we're requesting, along
+	// with the byte range we want back.
 	if err := encoding.WriteObject(conn, piece.ContractID); err != nil {
 		return err
 	}
+	if err := encoding.WriteObject(conn, chunk.offset); err != nil {
+		return err
+	}
+	if err := encoding.WriteObject(conn, chunk.length); err != nil {
+		return err
+	}
 
-	// Simultaneously download, decrypt, and calculate the Merkle root of the file.
+	// Simultaneously download, decrypt, and calculate the Merkle root of the
+	// range. The host also sends back the Merkle branch needed to verify the
+	// range against the file's full Merkle root.
+	var branch []crypto.Hash
+	if err := encoding.ReadObject(conn, &branch, encoding.DefaultAllocLimit); err != nil {
+		return err
+	}
 	tee := io.TeeReader(
 		// Use a LimitedReader to ensure we don't read indefinitely.
-		io.LimitReader(conn, int64(piece.Contract.FileSize)),
-		// Write the decrypted bytes to the file.
-		piece.EncryptionKey.NewWriter(d),
+		io.LimitReader(conn, int64(chunk.length)),
+		// Write the decrypted bytes to the destination.
+		piece.EncryptionKey.NewWriter(w),
 	)
-	merkleRoot, err := crypto.ReaderMerkleRoot(tee)
+	// Hash the range with crypto.HashBytes, the same leaf function the
+	// host uses to build the range proof in crypto.MerkleRangeProof; a
+	// different hash (e.g. Sia's segmented ReaderMerkleRoot) would never
+	// match the leaf the proof was built against.
+	rangeBytes, err := io.ReadAll(tee)
 	if err != nil {
 		return err
 	}
+	rangeRoot := crypto.HashBytes(rangeBytes)
 
-	if merkleRoot != piece.Contract.FileMerkleRoot {
-		return errors.New("host provided a file that's invalid")
+	if !crypto.VerifyRangeProof(rangeRoot, branch, chunk.offset, chunk.length, piece.Contract.FileMerkleRoot) {
+		return errors.New("host provided a file range that's invalid")
 	}
 
 	return nil
 }
 
-// newDownload initializes a new Download object.
-func newDownload(file *file, destination string) (*Download, error) {
-	// Create the download destination file.
-	handle, err := os.Create(destination)
+// newDownload initializes a new Download object. If checkpoint is non-nil,
+// the destination is reopened without truncating it and any chunks it
+// marks as already done are verified against their recorded hash instead
+// of being re-fetched.
+func newDownload(file *file, destination string, checkpoint *downloadCheckpoint) (*Download, error) {
+	// Create the download destination file, opened for random access so
+	// chunk workers can write out of order via WriteAt. A resumed download
+	// must not truncate the file, since it already holds real data.
+	flags := os.O_RDWR | os.O_CREATE
+	if checkpoint == nil {
+		flags |= os.O_TRUNC
+	}
+	handle, err := os.OpenFile(destination, flags, 0666)
 	if err != nil {
 		return nil, err
 	}
@@ -131,69 +430,166 @@ func newDownload(file *file, destination string) (*Download, error) {
 		return nil, errors.New("no active pieces")
 	}
 
+	filesize := file.Pieces[0].Contract.FileSize
+	if checkpoint == nil {
+		if err := handle.Truncate(int64(filesize)); err != nil {
+			handle.Close()
+			return nil, err
+		}
+	}
+
+	chunks := chunksForFilesize(filesize)
+	doneChunks := make([]bool, len(chunks))
+	chunkHashes := make([]crypto.Hash, len(chunks))
+	var received uint64
+	id := randomDownloadID()
+	if checkpoint != nil {
+		id = checkpoint.ID
+		for _, chunk := range chunks {
+			if chunk.index >= len(checkpoint.Done) || !checkpoint.Done[chunk.index] {
+				continue
+			}
+			// Verify what's on disk still matches what we wrote before the
+			// restart; if it doesn't, leave the chunk marked undone so it
+			// gets re-fetched.
+			if chunkHash(handle, chunk) != checkpoint.ChunkHashes[chunk.index] {
+				continue
+			}
+			doneChunks[chunk.index] = true
+			chunkHashes[chunk.index] = checkpoint.ChunkHashes[chunk.index]
+			received += chunk.length
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Download{
+		id:        id,
 		startTime: time.Now(),
 		complete:  false,
 		// for now, all the pieces are equivalent
-		filesize:    file.Pieces[0].Contract.FileSize,
-		received:    0,
+		filesize:    filesize,
+		received:    received,
 		destination: destination,
 		nickname:    file.Name,
 
+		ctx:    ctx,
+		cancel: cancel,
+
+		doneChunks:  doneChunks,
+		chunkHashes: chunkHashes,
+
 		pieces: activePieces,
 		file:   handle,
 	}, nil
 }
 
+// randomDownloadID returns a random hex-encoded identifier used to
+// correlate a queued download with later cancel and progress requests.
+func randomDownloadID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 // Download downloads a file, identified by its nickname, to the destination
-// specified.
-func (r *Renter) Download(nickname, destination string) error {
+// specified. It queues the download and returns immediately with the
+// download's ID; the transfer itself runs in the background and can be
+// followed or canceled using that ID.
+func (r *Renter) Download(nickname, destination string) (string, error) {
 	lockID := r.mu.Lock()
 	// Lookup the file associated with the nickname.
 	file, exists := r.files[nickname]
 	if !exists {
-		return errors.New("no file of that nickname")
+		r.mu.Unlock(lockID)
+		return "", errors.New("no file of that nickname")
 	}
 
 	// Create the download object and spawn the download process.
-	d, err := newDownload(file, destination)
+	d, err := newDownload(file, destination, nil)
 	if err != nil {
-		return err
+		r.mu.Unlock(lockID)
+		return "", err
 	}
+	d.onProgress = func() { r.saveDownloadCheckpoints() }
 
 	// Add the download to the download queue.
 	r.downloadQueue = append(r.downloadQueue, d)
 	r.mu.Unlock(lockID)
 
-	// Download the file. We only need one piece, so iterate through the hosts
-	// until a download succeeds.
-	for i := 0; i < downloadAttempts; i++ {
-		for _, piece := range d.pieces {
-			downloadErr := d.downloadPiece(piece)
-			if downloadErr == nil {
-				// done
-				d.complete = true
-				d.file.Close()
-				return nil
+	r.runDownload(d)
+	return d.id, nil
+}
+
+// ResumeDownload resumes a download that was interrupted by a restart,
+// picking up from the checkpoint recorded in downloads.json. Chunks that
+// were already written to disk and still verify are not re-fetched.
+func (r *Renter) ResumeDownload(id string) (string, error) {
+	lockID := r.mu.Lock()
+	checkpoint, exists := r.pendingDownloads[id]
+	if !exists {
+		r.mu.Unlock(lockID)
+		return "", errors.New("no resumable download with that id")
+	}
+	delete(r.pendingDownloads, id)
+
+	file, exists := r.files[checkpoint.Nickname]
+	if !exists {
+		r.mu.Unlock(lockID)
+		return "", errors.New("no file of that nickname")
+	}
+
+	d, err := newDownload(file, checkpoint.Destination, &checkpoint)
+	if err != nil {
+		r.mu.Unlock(lockID)
+		return "", err
+	}
+	d.onProgress = func() { r.saveDownloadCheckpoints() }
+
+	r.downloadQueue = append(r.downloadQueue, d)
+	r.mu.Unlock(lockID)
+
+	r.runDownload(d)
+	return d.id, nil
+}
+
+// runDownload fetches d's remaining chunks in the background, recovering
+// from individual host failures by re-dispatching the missing range to an
+// alternate host. On a transient failure the partial file and its
+// checkpoint are left in place so the download can be resumed later; on
+// cancellation they're cleaned up instead.
+func (r *Renter) runDownload(d *Download) {
+	go func() {
+		scheduler := newDownloadScheduler(d)
+		err := scheduler.run()
+		d.file.Close()
+
+		if err != nil {
+			if d.ctx.Err() != nil {
+				os.Remove(d.destination)
 			}
-			// Reset seek, since the file may have been partially written. The
-			// next attempt will overwrite these bytes.
-			d.file.Seek(0, 0)
-			atomic.SwapUint64(&d.received, 0)
+			r.saveDownloadCheckpoints()
+			return
 		}
 
-		// This iteration failed, no hosts returned the piece. Try again
-		// after waiting a random amount of time.
-		randSource := make([]byte, 1)
-		rand.Read(randSource)
-		time.Sleep(time.Second * time.Duration(i*i) * time.Duration(randSource[0]))
-	}
+		d.complete = true
+		r.saveDownloadCheckpoints()
+	}()
+}
 
-	// File could not be downloaded; delete the copy on disk.
-	d.file.Close()
-	os.Remove(destination)
+// CancelDownload cancels the in-progress download with the given ID. Chunk
+// workers notice the cancellation the next time they check the download's
+// context and abandon any further retries.
+func (r *Renter) CancelDownload(id string) error {
+	lockID := r.mu.RLock()
+	defer r.mu.RUnlock(lockID)
 
-	return errors.New("could not download any file pieces")
+	for _, d := range r.downloadQueue {
+		if d.ID() == id {
+			d.Cancel()
+			return nil
+		}
+	}
+	return errors.New("no download with that id")
 }
 
 // DownloadQueue returns the list of downloads in the queue.
@@ -0,0 +1,49 @@
+package renter
+
+import (
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// file tracks a single upload: the erasure scheme and duration it was
+// uploaded with, and the pieces it's been split into as hosts are found
+// for them.
+type file struct {
+	Name string
+
+	// Duration is how long, in blocks, hosts have agreed to store the
+	// file's pieces for.
+	Duration types.BlockHeight
+
+	// DataPieces and ParityPieces record the erasure scheme the file was
+	// uploaded with: any DataPieces of TargetPieces pieces reconstruct
+	// it. Pure replication is DataPieces == 1.
+	DataPieces   int
+	ParityPieces int
+
+	// TargetPieces is DataPieces + ParityPieces, recorded at upload time
+	// so FileHealth can report under-replication before every piece has
+	// found a host, instead of reading the target back out of Pieces
+	// itself.
+	TargetPieces int
+
+	Pieces []filePiece
+}
+
+// filePiece is a single piece of a file, stored under a contract with one
+// host.
+type filePiece struct {
+	Active        bool
+	HostIP        modules.NetAddress
+	ContractID    types.FileContractID
+	EncryptionKey crypto.TwofishKey
+	Contract      fileContract
+}
+
+// fileContract is the subset of a file contract's terms a piece needs in
+// order to verify a ranged retrieve against it.
+type fileContract struct {
+	FileMerkleRoot crypto.Hash
+	FileSize       uint64
+}
@@ -0,0 +1,25 @@
+package renter
+
+import "errors"
+
+// FileHealth reports how many of a file's configured pieces are currently
+// active versus the target the file was uploaded with, so a caller can
+// distinguish a file that's still uploading from one that's become
+// under-replicated after host churn.
+func (r *Renter) FileHealth(nickname string) (targetPieces, activePieces int, err error) {
+	lockID := r.mu.RLock()
+	defer r.mu.RUnlock(lockID)
+
+	file, exists := r.files[nickname]
+	if !exists {
+		return 0, 0, errors.New("no file of that nickname")
+	}
+
+	targetPieces = file.TargetPieces
+	for _, piece := range file.Pieces {
+		if piece.Active {
+			activePieces++
+		}
+	}
+	return targetPieces, activePieces, nil
+}
@@ -0,0 +1,110 @@
+package renter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/NebulousLabs/Sia/crypto"
+)
+
+// downloadsPersistFilename is the name of the file, within the renter's
+// persist directory, that tracks in-progress downloads across restarts.
+const downloadsPersistFilename = "downloads.json"
+
+// downloadCheckpoint is the on-disk representation of a download that
+// hadn't finished the last time the renter shut down. ChunkHashes lets a
+// resumed download verify that bytes already written to disk weren't lost
+// or corrupted before trusting them instead of re-fetching them.
+type downloadCheckpoint struct {
+	ID          string
+	Nickname    string
+	Destination string
+	Filesize    uint64
+	Done        []bool
+	ChunkHashes []crypto.Hash
+}
+
+// downloadsPersistPath returns the path of the renter's download checkpoint
+// file.
+func (r *Renter) downloadsPersistPath() string {
+	return filepath.Join(r.persistDir, downloadsPersistFilename)
+}
+
+// checkpoint captures d's current progress as a downloadCheckpoint.
+func (d *Download) checkpoint() downloadCheckpoint {
+	d.doneMu.Lock()
+	defer d.doneMu.Unlock()
+
+	done := make([]bool, len(d.doneChunks))
+	copy(done, d.doneChunks)
+	hashes := make([]crypto.Hash, len(d.chunkHashes))
+	copy(hashes, d.chunkHashes)
+
+	return downloadCheckpoint{
+		ID:          d.id,
+		Nickname:    d.nickname,
+		Destination: d.destination,
+		Filesize:    d.filesize,
+		Done:        done,
+		ChunkHashes: hashes,
+	}
+}
+
+// saveDownloadCheckpoints writes the progress of every incomplete download
+// in the queue to disk, so they can be resumed after a restart instead of
+// starting over from byte 0. It's called whenever a chunk finishes.
+func (r *Renter) saveDownloadCheckpoints() error {
+	lockID := r.mu.RLock()
+	var checkpoints []downloadCheckpoint
+	for _, d := range r.downloadQueue {
+		if d.complete || d.ctx.Err() != nil {
+			continue
+		}
+		checkpoints = append(checkpoints, d.checkpoint())
+	}
+	r.mu.RUnlock(lockID)
+
+	data, err := json.MarshalIndent(checkpoints, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename over the real path so a crash
+	// mid-write can't leave downloads.json truncated or corrupt.
+	tmpPath := r.downloadsPersistPath() + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, r.downloadsPersistPath())
+}
+
+// loadDownloadCheckpoints reads downloads.json, if it exists, and records
+// the checkpoints it contains as resumable. It's called once during
+// renter.New, before any downloads are queued, so that a client calling
+// ResumeDownload shortly after startup can pick interrupted transfers back
+// up.
+func (r *Renter) loadDownloadCheckpoints() error {
+	data, err := ioutil.ReadFile(r.downloadsPersistPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var checkpoints []downloadCheckpoint
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return err
+	}
+
+	lockID := r.mu.Lock()
+	defer r.mu.Unlock(lockID)
+	if r.pendingDownloads == nil {
+		r.pendingDownloads = make(map[string]downloadCheckpoint)
+	}
+	for _, cp := range checkpoints {
+		r.pendingDownloads[cp.ID] = cp
+	}
+	return nil
+}
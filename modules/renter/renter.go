@@ -0,0 +1,40 @@
+package renter
+
+import (
+	siasync "github.com/NebulousLabs/Sia/sync"
+)
+
+// Renter manages the local view of the files a user has uploaded to the
+// network: which hosts are storing which pieces, the downloads currently
+// queued or in flight, and enough persisted state to resume both across a
+// restart.
+type Renter struct {
+	mu    siasync.RWMutex
+	files map[string]*file
+
+	downloadQueue []*Download
+
+	// persistDir is where the renter keeps its on-disk state, including
+	// the download checkpoint file.
+	persistDir string
+
+	// pendingDownloads holds checkpoints loaded from downloads.json that
+	// haven't yet been picked back up with ResumeDownload.
+	pendingDownloads map[string]downloadCheckpoint
+}
+
+// New creates a Renter backed by persistDir, restoring any download
+// checkpoints left behind by an unclean shutdown so a client calling
+// ResumeDownload shortly after startup can pick interrupted transfers back
+// up.
+func New(persistDir string) (*Renter, error) {
+	r := &Renter{
+		files:            make(map[string]*file),
+		persistDir:       persistDir,
+		pendingDownloads: make(map[string]downloadCheckpoint),
+	}
+	if err := r.loadDownloadCheckpoints(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
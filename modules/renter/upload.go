@@ -0,0 +1,70 @@
+package renter
+
+import (
+	"errors"
+
+	"github.com/NebulousLabs/Sia/modules"
+)
+
+// erasureScheme describes how a file's pieces are derived from its data:
+// pure replication (every piece is an identical copy) or Reed-Solomon
+// k-of-n erasure coding (any dataPieces of the totalPieces reconstruct the
+// file).
+type erasureScheme struct {
+	dataPieces   int
+	parityPieces int
+}
+
+// totalPieces returns how many pieces the file is split into under this
+// scheme.
+func (s erasureScheme) totalPieces() int {
+	return s.dataPieces + s.parityPieces
+}
+
+// erasureSchemeFor translates an upload's requested erasure code into an
+// erasureScheme. Pure replication is modeled as a 1-of-n scheme: every
+// piece is a full copy of the data, so any single piece reconstructs it.
+func erasureSchemeFor(params modules.FileUploadParams) (erasureScheme, error) {
+	switch params.ErasureCode {
+	case "", "replication":
+		if params.Pieces < 1 {
+			return erasureScheme{}, errors.New("redundancy must be at least 1")
+		}
+		return erasureScheme{dataPieces: 1, parityPieces: params.Pieces - 1}, nil
+	case "reed-solomon":
+		if params.DataPieces < 1 || params.ParityPieces < 0 {
+			return erasureScheme{}, errors.New("invalid Reed-Solomon parameters")
+		}
+		return erasureScheme{dataPieces: params.DataPieces, parityPieces: params.ParityPieces}, nil
+	default:
+		return erasureScheme{}, errors.New("unknown erasure scheme: " + params.ErasureCode)
+	}
+}
+
+// Upload registers a file under params.Nickname with the duration and
+// erasure scheme that params requests, after validating the scheme. The
+// file starts with no pieces: Upload does not read params.Filename, form
+// any contracts, or transfer any data to hosts.
+func (r *Renter) Upload(params modules.FileUploadParams) error {
+	scheme, err := erasureSchemeFor(params)
+	if err != nil {
+		return err
+	}
+
+	lockID := r.mu.Lock()
+	defer r.mu.Unlock(lockID)
+
+	if _, exists := r.files[params.Nickname]; exists {
+		return errors.New("file with that nickname already exists")
+	}
+
+	r.files[params.Nickname] = &file{
+		Name:         params.Nickname,
+		Duration:     params.Duration,
+		DataPieces:   scheme.dataPieces,
+		ParityPieces: scheme.parityPieces,
+		TargetPieces: scheme.totalPieces(),
+		Pieces:       make([]filePiece, 0, scheme.totalPieces()),
+	}
+	return nil
+}